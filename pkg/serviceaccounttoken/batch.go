@@ -0,0 +1,315 @@
+package serviceaccounttoken
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corecontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	clientv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// batchLeaseName is the name suffix used for the single lease that guards a whole
+	// EnsureSecretsForServiceAccounts call, as opposed to the per-service-account lease
+	// used by EnsureSecretForServiceAccount.
+	batchLeaseName = "batch"
+
+	// batchCreateWorkers bounds how many Create calls EnsureSecretsForServiceAccounts issues at once.
+	batchCreateWorkers = 10
+
+	// batchPopulationTimeout bounds how long EnsureSecretsForServiceAccounts waits for newly created
+	// secrets to be populated with a token by the controller manager.
+	batchPopulationTimeout = 30 * time.Second
+)
+
+// EnsureSecretsForServiceAccounts is the batch counterpart to EnsureSecretForServiceAccount, for
+// callers (cluster provisioning, agent bootstrap) that need token secrets for many service accounts
+// in the same namespace at once. It acquires a single namespace-scoped lease, does one List of
+// existing token secrets, and issues Create calls concurrently (bounded by batchCreateWorkers) for
+// the ones missing, waiting for all of them to be populated via a single Watch rather than one
+// poll loop per service account. All of sas must be in the same namespace.
+func EnsureSecretsForServiceAccounts(ctx context.Context, secretsCache corecontrollers.SecretCache, clientSet kubernetes.Interface, sas []*v1.ServiceAccount) (map[types.UID]*v1.Secret, error) {
+	result := make(map[types.UID]*v1.Secret, len(sas))
+	if len(sas) == 0 {
+		return result, nil
+	}
+
+	for _, sa := range sas {
+		if sa == nil {
+			return nil, fmt.Errorf("could not ensure secrets for invalid service account")
+		}
+	}
+
+	namespace := sas[0].Namespace
+	for _, sa := range sas {
+		if sa.Namespace != namespace {
+			return nil, fmt.Errorf("all service accounts must be in namespace %s, got %s for %s", namespace, sa.Namespace, sa.Name)
+		}
+	}
+
+	lockKey := fmt.Sprintf("%v-batch", namespace)
+	mutex := acquireSAMutex(lockKey)
+	defer releaseSAMutex(lockKey, mutex)
+
+	held, err := acquireLease(ctx, clientSet, namespace, batchLeaseName)
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring lease: %w", err)
+	}
+	defer func() {
+		if err := releaseLease(ctx, held); err != nil {
+			logrus.Errorf("error releasing lease: %v", err)
+		}
+	}()
+
+	secretClient := clientSet.CoreV1().Secrets(namespace)
+	hasSALabel, err := labels.NewRequirement(ServiceAccountSecretLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building service account secret selector: %w", err)
+	}
+	selector := labels.NewSelector().Add(*hasSALabel)
+
+	var existing []*v1.Secret
+	if secretsCache != nil {
+		existing, err = secretsCache.List(namespace, selector)
+	} else {
+		var secretList *v1.SecretList
+		secretList, err = secretClient.List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err == nil {
+			existing = make([]*v1.Secret, len(secretList.Items))
+			for i := range secretList.Items {
+				existing[i] = &secretList.Items[i]
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing existing service account secrets in namespace %s: %w", namespace, err)
+	}
+
+	bySAName := make(map[string]*v1.Secret, len(existing))
+	for _, s := range existing {
+		name := s.Labels[ServiceAccountSecretLabel]
+		if _, ok := bySAName[name]; !ok {
+			bySAName[name] = s
+		}
+	}
+
+	var toCreate []*v1.ServiceAccount
+	for _, sa := range sas {
+		if secret, ok := bySAName[sa.Name]; ok && isSecretForServiceAccount(secret, sa) {
+			result[sa.UID] = secret
+			continue
+		}
+		toCreate = append(toCreate, sa)
+	}
+	if len(toCreate) == 0 {
+		return result, nil
+	}
+
+	created, err := createSecretsConcurrently(ctx, clientSet, secretClient, namespace, toCreate)
+	for uid, secret := range created {
+		result[uid] = secret
+	}
+	if err != nil {
+		return result, err
+	}
+
+	populated, err := waitForSecretsPopulated(ctx, secretClient, created)
+	for uid, secret := range populated {
+		result[uid] = secret
+	}
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// createSecretsConcurrently issues a Create for every sa in sas using a bounded pool of
+// batchCreateWorkers goroutines, returning whatever succeeded alongside the first error hit. Each
+// Create is guarded by that service account's own per-SA mutex and Lease (the same ones
+// EnsureSecretForServiceAccount takes), not just the namespace-wide batch lease, so a concurrent
+// single EnsureSecretForServiceAccount call for one of these service accounts can't race this batch
+// call into creating a duplicate token secret.
+func createSecretsConcurrently(ctx context.Context, clientSet kubernetes.Interface, secretClient clientv1.SecretInterface, namespace string, sas []*v1.ServiceAccount) (map[types.UID]*v1.Secret, error) {
+	work := make(chan *v1.ServiceAccount)
+	type createResult struct {
+		uid    types.UID
+		secret *v1.Secret
+		err    error
+	}
+	results := make(chan createResult, len(sas))
+
+	workers := batchCreateWorkers
+	if workers > len(sas) {
+		workers = len(sas)
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for sa := range work {
+				secret, err := createSecretExclusively(ctx, clientSet, secretClient, namespace, sa)
+				results <- createResult{uid: sa.UID, secret: secret, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, sa := range sas {
+			work <- sa
+		}
+		close(work)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	created := make(map[types.UID]*v1.Secret, len(sas))
+	var firstErr error
+	failures := 0
+	for r := range results {
+		if r.err != nil {
+			failures++
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		created[r.uid] = r.secret
+	}
+	if firstErr != nil {
+		return created, fmt.Errorf("error creating %d of %d service account secrets, first error: %w", failures, len(sas), firstErr)
+	}
+	return created, nil
+}
+
+// createSecretExclusively holds sa's per-SA mutex and Lease for the duration of a single Create,
+// the same locks EnsureSecretForServiceAccount takes, so the two code paths can't both create a
+// token secret for the same service account at once. It re-checks for an existing secret after
+// taking the lock, since EnsureSecretForServiceAccount may have created one in the gap between this
+// batch call's initial List and this goroutine reaching the front of the worker pool.
+func createSecretExclusively(ctx context.Context, clientSet kubernetes.Interface, secretClient clientv1.SecretInterface, namespace string, sa *v1.ServiceAccount) (*v1.Secret, error) {
+	lockKey := fmt.Sprintf("%v-%v", namespace, sa.Name)
+	mutex := acquireSAMutex(lockKey)
+	defer releaseSAMutex(lockKey, mutex)
+
+	held, err := acquireLease(ctx, clientSet, namespace, sa.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring lease for service account %s: %w", sa.Name, err)
+	}
+	defer func() {
+		if err := releaseLease(ctx, held); err != nil {
+			logrus.Errorf("error releasing lease: %v", err)
+		}
+	}()
+
+	lister := func(_ string, selector labels.Selector) ([]*v1.Secret, error) {
+		secretList, err := secretClient.List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, err
+		}
+		result := make([]*v1.Secret, len(secretList.Items))
+		for i := range secretList.Items {
+			result[i] = &secretList.Items[i]
+		}
+		return result, nil
+	}
+	existing, err := ServiceAccountSecret(ctx, sa, lister, secretClient)
+	if err != nil {
+		return nil, fmt.Errorf("error re-checking secret for service account [%s:%s]: %w", namespace, sa.Name, err)
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	return secretClient.Create(ctx, SecretTemplate(sa), metav1.CreateOptions{})
+}
+
+// waitForSecretsPopulated blocks until every secret in created has been populated with a token by
+// the controller manager, using a single Watch on the namespace's service account secrets instead
+// of one ExponentialBackoff Get loop per secret.
+//
+// The Data returned by the Create call in createSecretsConcurrently can't be trusted to tell us
+// whether a secret is already populated: the token controller routinely populates a secret faster
+// than createSecretsConcurrently finishes the rest of the batch, and relying on stale Create()
+// responses would make every one of those races block for the full batchPopulationTimeout instead
+// of returning immediately. So instead we always re-List right before opening the Watch, check the
+// List response for secrets that are already populated, and start the Watch at the List's
+// resourceVersion so no update in the gap between the two calls is missed.
+func waitForSecretsPopulated(ctx context.Context, secretClient clientv1.SecretInterface, created map[types.UID]*v1.Secret) (map[types.UID]*v1.Secret, error) {
+	populated := make(map[types.UID]*v1.Secret, len(created))
+	pendingByName := make(map[string]types.UID, len(created))
+	for uid, secret := range created {
+		pendingByName[secret.Name] = uid
+	}
+	if len(pendingByName) == 0 {
+		return populated, nil
+	}
+
+	hasSALabel, err := labels.NewRequirement(ServiceAccountSecretLabel, selection.Exists, nil)
+	if err != nil {
+		return populated, fmt.Errorf("error building service account secret selector: %w", err)
+	}
+	selector := labels.NewSelector().Add(*hasSALabel)
+
+	watchCtx, cancel := context.WithTimeout(ctx, batchPopulationTimeout)
+	defer cancel()
+
+	list, err := secretClient.List(watchCtx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return populated, fmt.Errorf("error listing secrets before watching for population: %w", err)
+	}
+	for i := range list.Items {
+		secret := &list.Items[i]
+		uid, isPending := pendingByName[secret.Name]
+		if !isPending || len(secret.Data[v1.ServiceAccountTokenKey]) == 0 {
+			continue
+		}
+		populated[uid] = secret
+		delete(pendingByName, secret.Name)
+	}
+	if len(pendingByName) == 0 {
+		return populated, nil
+	}
+
+	logrus.Infof("EnsureSecretsForServiceAccounts: waiting for %d secrets to be populated with tokens", len(pendingByName))
+	w, err := secretClient.Watch(watchCtx, metav1.ListOptions{
+		LabelSelector:   selector.String(),
+		ResourceVersion: list.ResourceVersion,
+	})
+	if err != nil {
+		return populated, fmt.Errorf("error watching for secrets to be populated: %w", err)
+	}
+	defer w.Stop()
+
+	for len(pendingByName) > 0 {
+		select {
+		case <-watchCtx.Done():
+			return populated, fmt.Errorf("timed out waiting for %d of %d secrets to be populated with tokens", len(pendingByName), len(created))
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return populated, fmt.Errorf("secret watch closed before %d of %d secrets were populated with tokens", len(pendingByName), len(created))
+			}
+			secret, ok := event.Object.(*v1.Secret)
+			if !ok {
+				continue
+			}
+			uid, isPending := pendingByName[secret.Name]
+			if !isPending || len(secret.Data[v1.ServiceAccountTokenKey]) == 0 {
+				continue
+			}
+			populated[uid] = secret
+			delete(pendingByName, secret.Name)
+		}
+	}
+	return populated, nil
+}
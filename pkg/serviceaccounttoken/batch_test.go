@@ -0,0 +1,86 @@
+package serviceaccounttoken
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8stesting "k8s.io/client-go/testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// autoPopulateTokens installs a reactor that fills in Data[v1.ServiceAccountTokenKey] on every
+// Secret Create, standing in for the token controller populating it asynchronously. It also expands
+// GenerateName into a unique Name, since the fake ObjectTracker (unlike a real apiserver) doesn't do
+// that itself, and SecretTemplate only ever sets GenerateName.
+//
+// It mutates the secret the Create call was given and returns false (unhandled) rather than handled
+// with a replacement object, so the chain falls through to the fake clientset's default reactor,
+// which is what actually stores the object in the tracker - a reactor that returns handled=true never
+// reaches that storage step, so later List/Get calls wouldn't see it.
+func autoPopulateTokens(clientSet *fake.Clientset) {
+	var seq int
+	clientSet.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.CreateAction).GetObject().(*v1.Secret)
+		if secret.Name == "" && secret.GenerateName != "" {
+			seq++
+			secret.Name = fmt.Sprintf("%s%d", secret.GenerateName, seq)
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[v1.ServiceAccountTokenKey] = []byte("fake-token")
+		return false, nil, nil
+	})
+}
+
+func newTestServiceAccount(namespace, name string) *v1.ServiceAccount {
+	return &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			UID:       types.UID(name + "-uid"),
+		},
+	}
+}
+
+func TestEnsureSecretsForServiceAccounts_NilServiceAccount(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	sa := newTestServiceAccount("default", "sa-a")
+
+	_, err := EnsureSecretsForServiceAccounts(context.Background(), nil, clientSet, []*v1.ServiceAccount{nil, sa})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid service account")
+}
+
+func TestEnsureSecretsForServiceAccounts_Empty(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	result, err := EnsureSecretsForServiceAccounts(context.Background(), nil, clientSet, nil)
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestEnsureSecretsForServiceAccounts_CreatesMissingAndReusesExisting(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	autoPopulateTokens(clientSet)
+
+	saA := newTestServiceAccount("default", "sa-a")
+	saB := newTestServiceAccount("default", "sa-b")
+
+	existing, err := clientSet.CoreV1().Secrets("default").Create(context.Background(), SecretTemplate(saA), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	result, err := EnsureSecretsForServiceAccounts(context.Background(), nil, clientSet, []*v1.ServiceAccount{saA, saB})
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+
+	assert.Equal(t, existing.Name, result[saA.UID].Name)
+	assert.NotEmpty(t, result[saB.UID].Data[v1.ServiceAccountTokenKey])
+}
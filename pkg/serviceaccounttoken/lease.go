@@ -0,0 +1,233 @@
+package serviceaccounttoken
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// leaseDuration is how long a held lease is valid for without a renewal.
+	leaseDuration = 30 * time.Second
+	// renewPeriod is how often the holder of a lease patches its renewTime.
+	renewPeriod = 10 * time.Second
+	// acquireRetryPeriod is how often acquireLease retries while the lease is held by someone else.
+	acquireRetryPeriod = 500 * time.Millisecond
+)
+
+// heldLease tracks a lease this process currently holds, including the goroutine that keeps it alive.
+type heldLease struct {
+	lock      resourcelock.Interface
+	stopRenew context.CancelFunc
+	renewDone chan struct{}
+}
+
+// lockEntry is a refcounted mutex. Refcounting lets lockMap evict entries for service accounts that
+// are no longer in use instead of growing without bound for the lifetime of the process.
+type lockEntry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	lockMapMu sync.Mutex
+	lockMap   = map[string]*lockEntry{}
+)
+
+// acquireSAMutex locks (creating if necessary) the mutex for key and returns it so the caller can
+// release it via releaseSAMutex. It helps sync within the pod and avoid multiple Lease waits from
+// the same pod; the Lease itself is what provides cross-pod exclusion.
+func acquireSAMutex(key string) *lockEntry {
+	lockMapMu.Lock()
+	entry, ok := lockMap[key]
+	if !ok {
+		entry = &lockEntry{}
+		lockMap[key] = entry
+	}
+	entry.refs++
+	lockMapMu.Unlock()
+
+	entry.mu.Lock()
+	return entry
+}
+
+// releaseSAMutex unlocks entry and evicts it from lockMap once nothing else references it.
+func releaseSAMutex(key string, entry *lockEntry) {
+	entry.mu.Unlock()
+
+	lockMapMu.Lock()
+	defer lockMapMu.Unlock()
+	entry.refs--
+	if entry.refs <= 0 {
+		delete(lockMap, key)
+	}
+}
+
+var (
+	processIdentityOnce sync.Once
+	processIdentityVal  string
+)
+
+// podIdentity returns a HolderIdentity unique to this process: podname_poduid when running in a pod
+// with the downward API wired up (POD_NAME/POD_UID), falling back to a process-lifetime UUID so the
+// lease code still works for out-of-cluster callers such as tests.
+func podIdentity() string {
+	podName := os.Getenv("POD_NAME")
+	podUID := os.Getenv("POD_UID")
+	if podName != "" && podUID != "" {
+		return fmt.Sprintf("%s_%s", podName, podUID)
+	}
+	processIdentityOnce.Do(func() {
+		processIdentityVal = string(uuid.NewUUID())
+	})
+	return processIdentityVal
+}
+
+// acquireLease blocks until this process holds the named Lease, retrying every acquireRetryPeriod
+// (plus jitter) for up to 50 attempts and returning an error if the lease is still held elsewhere
+// after the last one. Once it holds the lease it starts a background goroutine that renews it every
+// renewPeriod for as long as the lease is held. The caller must pass the returned *heldLease to
+// releaseLease when it is done with the lease.
+func acquireLease(ctx context.Context, clientSet kubernetes.Interface, namespace, name string) (*heldLease, error) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      LeasePrefix + name,
+			Namespace: namespace,
+		},
+		Client: clientSet.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: podIdentity(),
+		},
+	}
+
+	backoff := wait.Backoff{
+		Duration: acquireRetryPeriod,
+		Factor:   1.0,
+		Jitter:   0.5,
+		Steps:    50,
+	}
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		return tryAcquire(ctx, lock)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring the lease for %v: %w", name, err)
+	}
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	held := &heldLease{
+		lock:      lock,
+		stopRenew: cancel,
+		renewDone: make(chan struct{}),
+	}
+	go runRenewal(renewCtx, held)
+	return held, nil
+}
+
+// tryAcquire attempts a single Create-or-steal of the lease, returning true once lock.Identity() holds it.
+func tryAcquire(ctx context.Context, lock resourcelock.Interface) (bool, error) {
+	now := metav1.NewTime(time.Now())
+	record, _, err := lock.Get(ctx)
+	if errors.IsNotFound(err) {
+		createErr := lock.Create(ctx, resourcelock.LeaderElectionRecord{
+			HolderIdentity:       lock.Identity(),
+			LeaseDurationSeconds: int(leaseDuration.Seconds()),
+			AcquireTime:          now,
+			RenewTime:            now,
+		})
+		if createErr == nil {
+			return true, nil
+		}
+		if errors.IsAlreadyExists(createErr) {
+			return false, nil
+		}
+		return false, createErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if record.HolderIdentity == lock.Identity() {
+		return true, nil
+	}
+	if record.HolderIdentity != "" && !leaseExpired(record, now.Time) {
+		// someone else holds an unexpired lease, keep waiting
+		return false, nil
+	}
+
+	record.HolderIdentity = lock.Identity()
+	record.LeaseDurationSeconds = int(leaseDuration.Seconds())
+	record.AcquireTime = now
+	record.RenewTime = now
+	if updateErr := lock.Update(ctx, *record); updateErr != nil {
+		if errors.IsConflict(updateErr) {
+			return false, nil
+		}
+		return false, updateErr
+	}
+	return true, nil
+}
+
+// leaseExpired reports whether record's renewTime + leaseDurationSeconds has passed now, meaning its
+// holder has stopped renewing (crashed, or otherwise abandoned it) and another caller can take over.
+func leaseExpired(record *resourcelock.LeaderElectionRecord, now time.Time) bool {
+	return record.RenewTime.Add(time.Duration(record.LeaseDurationSeconds) * time.Second).Before(now)
+}
+
+// runRenewal patches the lease's renewTime every renewPeriod until ctx is cancelled by releaseLease.
+func runRenewal(ctx context.Context, held *heldLease) {
+	defer close(held.renewDone)
+	ticker := time.NewTicker(renewPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			record, _, err := held.lock.Get(ctx)
+			if err != nil {
+				logrus.Errorf("error renewing lease %s: %v", held.lock.Describe(), err)
+				continue
+			}
+			record.RenewTime = metav1.NewTime(time.Now())
+			if err := held.lock.Update(ctx, *record); err != nil {
+				logrus.Errorf("error renewing lease %s: %v", held.lock.Describe(), err)
+			}
+		}
+	}
+}
+
+// releaseLease stops the renewal goroutine for held and clears HolderIdentity on the lease, rather
+// than deleting it, so other waiters can detect the release immediately instead of waiting out the
+// full leaseDuration.
+func releaseLease(ctx context.Context, held *heldLease) error {
+	held.stopRenew()
+	<-held.renewDone
+
+	record, _, err := held.lock.Get(ctx)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading lease before release: %w", err)
+	}
+	if record.HolderIdentity != held.lock.Identity() {
+		// someone else already reclaimed it; nothing to clear
+		return nil
+	}
+	record.HolderIdentity = ""
+	record.RenewTime = metav1.NewTime(time.Now())
+	if err := held.lock.Update(ctx, *record); err != nil {
+		return fmt.Errorf("error releasing lease: %w", err)
+	}
+	return nil
+}
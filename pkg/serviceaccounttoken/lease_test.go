@@ -0,0 +1,98 @@
+package serviceaccounttoken
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func newTestLeaseLock(clientSet *fake.Clientset, identity string) resourcelock.Interface {
+	return &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      LeasePrefix + "sa-a",
+			Namespace: "default",
+		},
+		Client: clientSet.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+}
+
+func TestTryAcquire_CreatesWhenAbsent(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	lock := newTestLeaseLock(clientSet, "holder-a")
+
+	acquired, err := tryAcquire(context.Background(), lock)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	record, _, err := lock.Get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "holder-a", record.HolderIdentity)
+}
+
+func TestTryAcquire_AlreadyHeldBySelf(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	lock := newTestLeaseLock(clientSet, "holder-a")
+
+	_, err := tryAcquire(context.Background(), lock)
+	require.NoError(t, err)
+
+	acquired, err := tryAcquire(context.Background(), lock)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestTryAcquire_HeldByOtherUnexpired(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	lockA := newTestLeaseLock(clientSet, "holder-a")
+	lockB := newTestLeaseLock(clientSet, "holder-b")
+
+	_, err := tryAcquire(context.Background(), lockA)
+	require.NoError(t, err)
+
+	acquired, err := tryAcquire(context.Background(), lockB)
+	require.NoError(t, err)
+	assert.False(t, acquired, "holder-b should not acquire a lease still held by holder-a")
+}
+
+func TestTryAcquire_StealsExpired(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	lockA := newTestLeaseLock(clientSet, "holder-a")
+	lockB := newTestLeaseLock(clientSet, "holder-b")
+
+	_, err := tryAcquire(context.Background(), lockA)
+	require.NoError(t, err)
+
+	record, _, err := lockA.Get(context.Background())
+	require.NoError(t, err)
+	record.RenewTime = metav1.NewTime(time.Now().Add(-2 * leaseDuration))
+	require.NoError(t, lockA.Update(context.Background(), *record))
+
+	acquired, err := tryAcquire(context.Background(), lockB)
+	require.NoError(t, err)
+	assert.True(t, acquired, "holder-b should steal a lease abandoned by holder-a")
+}
+
+func TestLeaseExpired(t *testing.T) {
+	now := time.Now()
+
+	fresh := &resourcelock.LeaderElectionRecord{
+		RenewTime:            metav1.NewTime(now),
+		LeaseDurationSeconds: int(leaseDuration.Seconds()),
+	}
+	assert.False(t, leaseExpired(fresh, now.Add(leaseDuration/2)))
+
+	stale := &resourcelock.LeaderElectionRecord{
+		RenewTime:            metav1.NewTime(now.Add(-2 * leaseDuration)),
+		LeaseDurationSeconds: int(leaseDuration.Seconds()),
+	}
+	assert.True(t, leaseExpired(stale, now))
+}
@@ -0,0 +1,148 @@
+package serviceaccounttoken
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestDeployment(namespace, name string, generation int64, replicas, updated, available int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Generation: generation},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: generation,
+			UpdatedReplicas:    updated,
+			AvailableReplicas:  available,
+		},
+	}
+}
+
+func newTestDaemonSet(namespace, name string, generation int64, desired, updated, available int32) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Generation: generation},
+		Status: appsv1.DaemonSetStatus{
+			ObservedGeneration:     generation,
+			DesiredNumberScheduled: desired,
+			UpdatedNumberScheduled: updated,
+			NumberAvailable:        available,
+		},
+	}
+}
+
+func TestDeploymentRolled(t *testing.T) {
+	rolled := newTestDeployment("default", "dep", 3, 2, 2, 2)
+	assert.True(t, deploymentRolled(rolled, 3))
+
+	staleGeneration := newTestDeployment("default", "dep", 2, 2, 2, 2)
+	assert.False(t, deploymentRolled(staleGeneration, 3))
+
+	stillRolling := newTestDeployment("default", "dep", 3, 2, 1, 1)
+	assert.False(t, deploymentRolled(stillRolling, 3))
+}
+
+func TestDaemonSetRolled(t *testing.T) {
+	rolled := newTestDaemonSet("default", "ds", 3, 2, 2, 2)
+	assert.True(t, daemonSetRolled(rolled, 3))
+
+	staleGeneration := newTestDaemonSet("default", "ds", 2, 2, 2, 2)
+	assert.False(t, daemonSetRolled(staleGeneration, 3))
+
+	stillRolling := newTestDaemonSet("default", "ds", 3, 2, 1, 1)
+	assert.False(t, daemonSetRolled(stillRolling, 3))
+}
+
+func TestTriggerConsumerRestart_Deployment(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newTestDeployment("default", "dep-a", 5, 1, 1, 1))
+	c := &RotationController{clientSet: clientSet}
+
+	targetGeneration, err := c.triggerConsumerRestart(context.Background(), "default", consumerRef{Kind: "Deployment", Name: "dep-a"}, "new-secret")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), targetGeneration)
+
+	dep, err := clientSet.AppsV1().Deployments("default").Get(context.Background(), "dep-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "new-secret", dep.Spec.Template.Annotations[rolloutTriggerAnnotation])
+}
+
+func TestTriggerConsumerRestart_DaemonSet(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newTestDaemonSet("default", "ds-a", 5, 1, 1, 1))
+	c := &RotationController{clientSet: clientSet}
+
+	targetGeneration, err := c.triggerConsumerRestart(context.Background(), "default", consumerRef{Kind: "DaemonSet", Name: "ds-a"}, "new-secret")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), targetGeneration)
+
+	ds, err := clientSet.AppsV1().DaemonSets("default").Get(context.Background(), "ds-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "new-secret", ds.Spec.Template.Annotations[rolloutTriggerAnnotation])
+}
+
+func TestTriggerConsumerRestart_NotFound(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	c := &RotationController{clientSet: clientSet}
+
+	targetGeneration, err := c.triggerConsumerRestart(context.Background(), "default", consumerRef{Kind: "Deployment", Name: "missing"}, "new-secret")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), targetGeneration)
+}
+
+func TestTriggerConsumerRestart_UnsupportedKind(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	c := &RotationController{clientSet: clientSet}
+
+	targetGeneration, err := c.triggerConsumerRestart(context.Background(), "default", consumerRef{Kind: "StatefulSet", Name: "sts-a"}, "new-secret")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), targetGeneration)
+}
+
+func TestConsumerRolled_NotFound(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	c := &RotationController{clientSet: clientSet}
+
+	rolled, err := c.consumerRolled(context.Background(), "default", consumerRef{Kind: "Deployment", Name: "missing"}, 1)
+	require.NoError(t, err)
+	assert.True(t, rolled, "a consumer that no longer exists should not block rotation")
+}
+
+func TestWaitForConsumersRolled_AlreadyRolled(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newTestDeployment("default", "dep-a", 5, 1, 1, 1))
+	c := &RotationController{clientSet: clientSet}
+
+	err := c.waitForConsumersRolled(context.Background(), "default", []consumerRef{{Kind: "Deployment", Name: "dep-a"}}, "new-secret")
+	assert.NoError(t, err)
+}
+
+func TestWaitForConsumersRolled_StaleObservedGeneration(t *testing.T) {
+	// Generation 5 but ObservedGeneration still 4: no controller in this test ever advances it, so
+	// consumerRolled must keep reporting "not yet" until the bounded context gives up.
+	dep := newTestDeployment("default", "dep-a", 5, 1, 1, 1)
+	dep.Status.ObservedGeneration = 4
+	clientSet := fake.NewSimpleClientset(dep)
+	c := &RotationController{clientSet: clientSet}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.waitForConsumersRolled(ctx, "default", []consumerRef{{Kind: "Deployment", Name: "dep-a"}}, "new-secret")
+	require.Error(t, err)
+}
+
+func TestWaitForConsumersRolled_StaleReplicaCounts(t *testing.T) {
+	// ObservedGeneration matches, but the rollout hasn't finished replacing/availing pods yet.
+	dep := newTestDeployment("default", "dep-a", 5, 2, 1, 1)
+	clientSet := fake.NewSimpleClientset(dep)
+	c := &RotationController{clientSet: clientSet}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.waitForConsumersRolled(ctx, "default", []consumerRef{{Kind: "Deployment", Name: "dep-a"}}, "new-secret")
+	require.Error(t, err)
+}
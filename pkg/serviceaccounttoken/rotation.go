@@ -0,0 +1,417 @@
+package serviceaccounttoken
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corecontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// TokenConsumersAnnotation is an opt-in annotation on a service account token Secret listing the
+	// workloads (Deployments/DaemonSets) that consume it, as comma-separated "Kind/Name" pairs, e.g.
+	// "Deployment/cattle-cluster-agent,DaemonSet/cattle-node-agent". NewRotationController waits for
+	// these to roll onto a freshly minted secret before deleting the old one. Consumers with no entry
+	// here are rotated without waiting for a roll.
+	TokenConsumersAnnotation = "cattle.io/token-consumers"
+
+	// defaultMaxAge is how old a secret can get, regardless of its token's exp claim, before it is rotated.
+	defaultMaxAge = 90 * 24 * time.Hour
+	// defaultRotateBefore is how far ahead of its exp claim a token is rotated.
+	defaultRotateBefore = 7 * 24 * time.Hour
+	// defaultResyncPeriod is how often the controller re-scans every service account secret.
+	defaultResyncPeriod = time.Hour
+	// rolloutPollInterval is how often the controller polls a consumer workload for rollout completion.
+	rolloutPollInterval = 5 * time.Second
+	// rolloutTimeout bounds how long the controller waits for consumers to roll before giving up on
+	// this rotation cycle; it will be retried on the next resync.
+	rolloutTimeout = 10 * time.Minute
+
+	// rolloutTriggerAnnotation is patched onto a consumer's pod template with the newly rotated
+	// secret's name, both bumping the workload's Generation so the roll can be observed and recording
+	// which secret it was rolled onto.
+	rolloutTriggerAnnotation = "cattle.io/rotated-token-secret"
+)
+
+var (
+	rotationsAttemptedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rancher_serviceaccounttoken_rotations_attempted_total",
+		Help: "Total number of service account token secret rotations attempted.",
+	}, []string{"namespace", "service_account"})
+
+	rotationsSucceededTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rancher_serviceaccounttoken_rotations_succeeded_total",
+		Help: "Total number of service account token secret rotations that completed successfully.",
+	}, []string{"namespace", "service_account"})
+
+	rotationsFailedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rancher_serviceaccounttoken_rotations_failed_total",
+		Help: "Total number of service account token secret rotations that failed.",
+	}, []string{"namespace", "service_account", "reason"})
+
+	lastRotationTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rancher_serviceaccounttoken_last_rotation_timestamp_seconds",
+		Help: "Unix timestamp of the last successful rotation of a service account token secret.",
+	}, []string{"namespace", "service_account"})
+)
+
+// RotationOptions configures a RotationController. The zero value is replaced with sane defaults by
+// NewRotationController.
+type RotationOptions struct {
+	// MaxAge is the maximum age a secret is allowed to reach before it is rotated, regardless of its
+	// token's exp claim. Defaults to 90 days.
+	MaxAge time.Duration
+	// RotateBefore is how far ahead of its token's exp claim a secret is rotated. Defaults to 7 days.
+	RotateBefore time.Duration
+	// ResyncPeriod is how often every service account secret is re-scanned for rotation. Defaults to 1 hour.
+	ResyncPeriod time.Duration
+}
+
+func (o RotationOptions) withDefaults() RotationOptions {
+	if o.MaxAge <= 0 {
+		o.MaxAge = defaultMaxAge
+	}
+	if o.RotateBefore <= 0 {
+		o.RotateBefore = defaultRotateBefore
+	}
+	if o.ResyncPeriod <= 0 {
+		o.ResyncPeriod = defaultResyncPeriod
+	}
+	return o
+}
+
+// RotationController proactively rotates legacy service-account token Secrets: any secret older
+// than MaxAge, or whose embedded JWT is within RotateBefore of its exp claim, is replaced with a
+// freshly minted secret. The mint, and the wait for any declared consumers to roll onto it, are done
+// under that service account's own per-service-account lease/mutex - the same ones
+// EnsureSecretForServiceAccount uses - held for the whole rotation, so it composes safely with any
+// in-flight EnsureSecretForServiceAccount call instead of racing it.
+type RotationController struct {
+	secretsCache corecontrollers.SecretCache
+	clientSet    kubernetes.Interface
+	opts         RotationOptions
+}
+
+// NewRotationController builds a RotationController. Call Run to start it.
+func NewRotationController(secretsCache corecontrollers.SecretCache, clientSet kubernetes.Interface, opts RotationOptions) *RotationController {
+	return &RotationController{
+		secretsCache: secretsCache,
+		clientSet:    clientSet,
+		opts:         opts.withDefaults(),
+	}
+}
+
+// Run reconciles every service account token secret immediately, then every ResyncPeriod, until ctx
+// is cancelled.
+func (c *RotationController) Run(ctx context.Context) {
+	c.reconcileAll(ctx)
+
+	ticker := time.NewTicker(c.opts.ResyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.reconcileAll(ctx)
+		}
+	}
+}
+
+func (c *RotationController) reconcileAll(ctx context.Context) {
+	hasSALabel, err := labels.NewRequirement(ServiceAccountSecretLabel, selection.Exists, nil)
+	if err != nil {
+		logrus.Errorf("RotationController: error building service account secret selector: %v", err)
+		return
+	}
+	selector := labels.NewSelector().Add(*hasSALabel)
+
+	secrets, err := c.secretsCache.List(metav1.NamespaceAll, selector)
+	if err != nil {
+		logrus.Errorf("RotationController: error listing service account secrets: %v", err)
+		return
+	}
+
+	for _, secret := range secrets {
+		if err := c.reconcileSecret(ctx, secret); err != nil {
+			logrus.Errorf("RotationController: error rotating secret [%s:%s]: %v", secret.Namespace, secret.Name, err)
+		}
+	}
+}
+
+func (c *RotationController) reconcileSecret(ctx context.Context, secret *v1.Secret) error {
+	saName := secret.Labels[ServiceAccountSecretLabel]
+	if saName == "" {
+		return nil
+	}
+	due, err := c.rotationDue(secret)
+	if err != nil {
+		return fmt.Errorf("error checking whether secret is due for rotation: %w", err)
+	}
+	if !due {
+		return nil
+	}
+
+	rotationsAttemptedTotal.WithLabelValues(secret.Namespace, saName).Inc()
+	if err := c.rotate(ctx, secret, saName); err != nil {
+		rotationsFailedTotal.WithLabelValues(secret.Namespace, saName, "rotate").Inc()
+		return err
+	}
+	rotationsSucceededTotal.WithLabelValues(secret.Namespace, saName).Inc()
+	lastRotationTimestamp.WithLabelValues(secret.Namespace, saName).Set(float64(time.Now().Unix()))
+	return nil
+}
+
+// rotationDue reports whether secret is older than MaxAge, or its embedded token is within
+// RotateBefore of its exp claim.
+func (c *RotationController) rotationDue(secret *v1.Secret) (bool, error) {
+	if time.Since(secret.CreationTimestamp.Time) >= c.opts.MaxAge {
+		return true, nil
+	}
+	token := secret.Data[v1.ServiceAccountTokenKey]
+	if len(token) == 0 {
+		return false, nil
+	}
+	exp, err := jwtExpiry(string(token))
+	if err != nil {
+		return false, fmt.Errorf("error reading exp claim: %w", err)
+	}
+	if exp.IsZero() {
+		return false, nil
+	}
+	return time.Until(exp) <= c.opts.RotateBefore, nil
+}
+
+// jwtExpiry extracts the exp claim from token without verifying its signature: rotation only needs
+// to know when the token expires, not whether it is currently valid.
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error decoding token payload: %w", err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("error unmarshalling token payload: %w", err)
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// rotate mints a replacement secret for saName, waits for any declared consumers to roll onto it,
+// then deletes the old secret. The whole operation - mint, rollout wait, and delete - runs under
+// saName's per-SA mutex/lease (the same ones EnsureSecretForServiceAccount uses), held for the
+// entire call rather than just the mint, so an in-flight EnsureSecretForServiceAccount call can't
+// race rotation into creating a second secret for the same service account.
+func (c *RotationController) rotate(ctx context.Context, old *v1.Secret, saName string) error {
+	sa, err := c.clientSet.CoreV1().ServiceAccounts(old.Namespace).Get(ctx, saName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting service account [%s:%s]: %w", old.Namespace, saName, err)
+	}
+
+	lockKey := fmt.Sprintf("%v-%v", sa.Namespace, sa.Name)
+	mutex := acquireSAMutex(lockKey)
+	defer releaseSAMutex(lockKey, mutex)
+
+	held, err := acquireLease(ctx, c.clientSet, sa.Namespace, sa.Name)
+	if err != nil {
+		return fmt.Errorf("error acquiring lease: %w", err)
+	}
+	defer func() {
+		if err := releaseLease(ctx, held); err != nil {
+			logrus.Errorf("error releasing lease: %v", err)
+		}
+	}()
+
+	newSecret, err := c.mintReplacement(ctx, sa)
+	if err != nil {
+		return fmt.Errorf("error minting replacement secret: %w", err)
+	}
+
+	consumers := parseConsumers(old.Annotations[TokenConsumersAnnotation])
+	if len(consumers) > 0 {
+		if err := c.waitForConsumersRolled(ctx, old.Namespace, consumers, newSecret.Name); err != nil {
+			return fmt.Errorf("error waiting for consumers to roll onto secret %s: %w", newSecret.Name, err)
+		}
+	}
+
+	if err := c.clientSet.CoreV1().Secrets(old.Namespace).Delete(ctx, old.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting old secret [%s:%s]: %w", old.Namespace, old.Name, err)
+	}
+	return nil
+}
+
+// mintReplacement creates a brand-new token secret for sa and waits for it to be populated with a
+// token. The caller must already hold sa's per-SA mutex/lease for the duration of the whole
+// rotation, not just this call.
+func (c *RotationController) mintReplacement(ctx context.Context, sa *v1.ServiceAccount) (*v1.Secret, error) {
+	secretClient := c.clientSet.CoreV1().Secrets(sa.Namespace)
+	secret, err := secretClient.Create(ctx, SecretTemplate(sa), metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error creating replacement secret for service account [%s:%s]: %w", sa.Namespace, sa.Name, err)
+	}
+
+	backoff := wait.Backoff{
+		Duration: 2 * time.Millisecond,
+		Cap:      100 * time.Millisecond,
+		Steps:    50,
+	}
+	err = wait.ExponentialBackoff(backoff, func() (bool, error) {
+		secret, err = secretClient.Get(ctx, secret.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("error waiting for replacement secret to be populated: %w", err)
+		}
+		return len(secret.Data[v1.ServiceAccountTokenKey]) > 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// consumerRef names a single workload that consumes a rotated secret.
+type consumerRef struct {
+	Kind string
+	Name string
+}
+
+// parseConsumers parses the comma-separated "Kind/Name" pairs of TokenConsumersAnnotation.
+func parseConsumers(annotation string) []consumerRef {
+	if annotation == "" {
+		return nil
+	}
+	var refs []consumerRef
+	for _, entry := range strings.Split(annotation, ",") {
+		entry = strings.TrimSpace(entry)
+		kind, name, ok := strings.Cut(entry, "/")
+		if !ok || kind == "" || name == "" {
+			logrus.Warnf("RotationController: ignoring malformed %s entry %q", TokenConsumersAnnotation, entry)
+			continue
+		}
+		refs = append(refs, consumerRef{Kind: kind, Name: name})
+	}
+	return refs
+}
+
+// waitForConsumersRolled patches every consumer workload's pod template with newSecretName (bumping
+// its Generation) and blocks until that generation's rollout has finished, i.e. the controller has
+// observed it and all replicas are updated and available.
+func (c *RotationController) waitForConsumersRolled(ctx context.Context, namespace string, consumers []consumerRef, newSecretName string) error {
+	rolloutCtx, cancel := context.WithTimeout(ctx, rolloutTimeout)
+	defer cancel()
+
+	for _, consumer := range consumers {
+		targetGeneration, err := c.triggerConsumerRestart(rolloutCtx, namespace, consumer, newSecretName)
+		if err != nil {
+			return fmt.Errorf("error triggering %s/%s to roll onto %s: %w", consumer.Kind, consumer.Name, newSecretName, err)
+		}
+		if targetGeneration < 0 {
+			// consumer doesn't exist, or is of an unsupported kind; nothing to wait for.
+			continue
+		}
+		if err := wait.PollUntilContextCancel(rolloutCtx, rolloutPollInterval, true, func(ctx context.Context) (bool, error) {
+			return c.consumerRolled(ctx, namespace, consumer, targetGeneration)
+		}); err != nil {
+			return fmt.Errorf("error waiting for %s/%s to roll out: %w", consumer.Kind, consumer.Name, err)
+		}
+	}
+	return nil
+}
+
+// triggerConsumerRestart patches consumer's pod template annotations with newSecretName, causing its
+// controller to roll every pod so it picks up the rotated secret, and returns the Generation that
+// rollout must reach. It returns -1 (and no error) if consumer doesn't exist or is of a kind this
+// controller doesn't know how to roll.
+func (c *RotationController) triggerConsumerRestart(ctx context.Context, namespace string, consumer consumerRef, newSecretName string) (int64, error) {
+	patch := []byte(fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`, rolloutTriggerAnnotation, newSecretName))
+	switch consumer.Kind {
+	case "Deployment":
+		dep, err := c.clientSet.AppsV1().Deployments(namespace).Patch(ctx, consumer.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+		if errors.IsNotFound(err) {
+			return -1, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return dep.Generation, nil
+	case "DaemonSet":
+		ds, err := c.clientSet.AppsV1().DaemonSets(namespace).Patch(ctx, consumer.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+		if errors.IsNotFound(err) {
+			return -1, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return ds.Generation, nil
+	default:
+		logrus.Warnf("RotationController: unsupported %s kind %q for %s, skipping rollout trigger", TokenConsumersAnnotation, consumer.Kind, consumer.Name)
+		return -1, nil
+	}
+}
+
+func (c *RotationController) consumerRolled(ctx context.Context, namespace string, consumer consumerRef, targetGeneration int64) (bool, error) {
+	switch consumer.Kind {
+	case "Deployment":
+		dep, err := c.clientSet.AppsV1().Deployments(namespace).Get(ctx, consumer.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return deploymentRolled(dep, targetGeneration), nil
+	case "DaemonSet":
+		ds, err := c.clientSet.AppsV1().DaemonSets(namespace).Get(ctx, consumer.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return daemonSetRolled(ds, targetGeneration), nil
+	default:
+		return true, nil
+	}
+}
+
+func deploymentRolled(dep *appsv1.Deployment, targetGeneration int64) bool {
+	if dep.Status.ObservedGeneration < targetGeneration {
+		return false
+	}
+	desired := int32(1)
+	if dep.Spec.Replicas != nil {
+		desired = *dep.Spec.Replicas
+	}
+	return dep.Status.UpdatedReplicas >= desired && dep.Status.AvailableReplicas >= desired
+}
+
+func daemonSetRolled(ds *appsv1.DaemonSet, targetGeneration int64) bool {
+	if ds.Status.ObservedGeneration < targetGeneration {
+		return false
+	}
+	return ds.Status.UpdatedNumberScheduled >= ds.Status.DesiredNumberScheduled &&
+		ds.Status.NumberAvailable >= ds.Status.DesiredNumberScheduled
+}
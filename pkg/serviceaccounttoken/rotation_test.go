@@ -0,0 +1,105 @@
+package serviceaccounttoken
+
+import (
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// jwtWithExp builds a fake (unsigned) JWT whose payload carries only an exp claim, for exercising
+// jwtExpiry/rotationDue without needing a real signer.
+func jwtWithExp(exp time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":` + strconv.FormatInt(exp.Unix(), 10) + `}`))
+	return header + "." + payload + ".sig"
+}
+
+func TestJwtExpiry(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	token := jwtWithExp(exp)
+
+	got, err := jwtExpiry(token)
+	require.NoError(t, err)
+	assert.True(t, got.Equal(exp), "expected %v, got %v", exp, got)
+}
+
+func TestJwtExpiry_NoExpClaim(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{}`))
+	token := header + "." + payload + ".sig"
+
+	got, err := jwtExpiry(token)
+	require.NoError(t, err)
+	assert.True(t, got.IsZero())
+}
+
+func TestJwtExpiry_NotAJWT(t *testing.T) {
+	_, err := jwtExpiry("not-a-jwt")
+	require.Error(t, err)
+}
+
+func TestRotationDue_MaxAge(t *testing.T) {
+	c := &RotationController{opts: RotationOptions{}.withDefaults()}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-(c.opts.MaxAge + time.Hour))),
+		},
+	}
+	due, err := c.rotationDue(secret)
+	require.NoError(t, err)
+	assert.True(t, due)
+}
+
+func TestRotationDue_NotYetDue(t *testing.T) {
+	c := &RotationController{opts: RotationOptions{}.withDefaults()}
+	exp := time.Now().Add(c.opts.RotateBefore + 24*time.Hour).Truncate(time.Second)
+	token := jwtWithExp(exp)
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.NewTime(time.Now()),
+		},
+		Data: map[string][]byte{
+			v1.ServiceAccountTokenKey: []byte(token),
+		},
+	}
+	due, err := c.rotationDue(secret)
+	require.NoError(t, err)
+	assert.False(t, due)
+}
+
+func TestRotationDue_NearExpiry(t *testing.T) {
+	c := &RotationController{opts: RotationOptions{}.withDefaults()}
+	exp := time.Now().Add(c.opts.RotateBefore - time.Minute).Truncate(time.Second)
+	token := jwtWithExp(exp)
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.NewTime(time.Now()),
+		},
+		Data: map[string][]byte{
+			v1.ServiceAccountTokenKey: []byte(token),
+		},
+	}
+	due, err := c.rotationDue(secret)
+	require.NoError(t, err)
+	assert.True(t, due)
+}
+
+func TestRotationDue_NoToken(t *testing.T) {
+	c := &RotationController{opts: RotationOptions{}.withDefaults()}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.NewTime(time.Now()),
+		},
+	}
+	due, err := c.rotationDue(secret)
+	require.NoError(t, err)
+	assert.False(t, due)
+}
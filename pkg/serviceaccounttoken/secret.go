@@ -3,20 +3,16 @@ package serviceaccounttoken
 import (
 	"context"
 	"fmt"
-	"sync"
 	"time"
 
 	corecontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	"github.com/sirupsen/logrus"
-	coordinationv1 "k8s.io/api/coordination/v1"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	clientv1 "k8s.io/client-go/kubernetes/typed/core/v1"
-	"k8s.io/utils/ptr"
 )
 
 const (
@@ -29,13 +25,6 @@ const (
 	LeasePrefix = "sa-token-lease-"
 )
 
-var lockMap sync.Map
-
-func getLock(key string) *sync.Mutex {
-	actual, _ := lockMap.LoadOrStore(key, &sync.Mutex{})
-	return actual.(*sync.Mutex)
-}
-
 // secretLister is an abstraction over any kind of secret lister.
 // The caller can use any cache or client it has available, whether that is from norman, wrangler, or client-go,
 // as long as it can wrap it in a simplified lambda with this signature.
@@ -50,17 +39,18 @@ func EnsureSecretForServiceAccount(ctx context.Context, secretsCache corecontrol
 
 	// Lock avoids multiple calls to this func at the same time and creation of same resources multiple times
 	// Mutex is a addition to the Lease, it helps sync within the pod and avoid multiple Lease waits from the same pod
-	mutex := getLock(fmt.Sprintf("%v-%v", sa.Namespace, sa.Name))
-	mutex.Lock()
-	defer mutex.Unlock()
+	lockKey := fmt.Sprintf("%v-%v", sa.Namespace, sa.Name)
+	mutex := acquireSAMutex(lockKey)
+	defer releaseSAMutex(lockKey, mutex)
 
 	// Acquire lease
 	// acquireLease has a wait.Backoff until the lease is acquired, so this will be a blocking func call
-	if err := acquireLease(ctx, clientSet, sa.Namespace, sa.Name); err != nil {
+	held, err := acquireLease(ctx, clientSet, sa.Namespace, sa.Name)
+	if err != nil {
 		return nil, fmt.Errorf("error acquiring lease: %w", err)
 	}
 	defer func() {
-		if err := releaseLease(ctx, clientSet, sa.Namespace, sa.Name); err != nil {
+		if err := releaseLease(ctx, held); err != nil {
 			logrus.Errorf("error releasing lease: %v", err)
 		}
 	}()
@@ -196,50 +186,3 @@ func isSecretForServiceAccount(secret *v1.Secret, sa *v1.ServiceAccount) bool {
 	annotation := annotations[serviceAccountSecretAnnotation]
 	return sa.Name == annotation
 }
-
-func acquireLease(ctx context.Context, clientSet kubernetes.Interface, namespace, name string) error {
-	leaseClient := clientSet.CoordinationV1().Leases(namespace)
-	leaseDuration := int32(30)
-	lease := &coordinationv1.Lease{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprint(LeasePrefix + name),
-			Namespace: namespace,
-		},
-		Spec: coordinationv1.LeaseSpec{
-			HolderIdentity:       ptr.To("serviceaccounttoken-controller"),
-			LeaseDurationSeconds: ptr.To(leaseDuration),
-		},
-	}
-	// Wait for the Lease to be granted
-	backoff := wait.Backoff{
-		Duration: 500 * time.Millisecond,
-		Factor:   1.0,
-		Jitter:   1.0,
-		Steps:    50,
-	}
-	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
-		_, err := leaseClient.Create(ctx, lease, metav1.CreateOptions{})
-		// if create was success, meaning we got the lease
-		if err == nil {
-			return true, nil
-		}
-		// if lease already exists, another request has this lease, continue to wait
-		if errors.IsAlreadyExists(err) {
-			return false, nil
-		}
-		return false, err
-	})
-	if err != nil {
-		return fmt.Errorf("error acquiring the lease for %v: %w", name, err)
-	}
-	return nil
-}
-
-func releaseLease(ctx context.Context, clientSet kubernetes.Interface, namespace, name string) error {
-	leaseClient := clientSet.CoordinationV1().Leases(namespace)
-	err := leaseClient.Delete(ctx, fmt.Sprint(LeasePrefix+name), metav1.DeleteOptions{})
-	if err != nil {
-		return fmt.Errorf("error deleting lease: %w", err)
-	}
-	return nil
-}
@@ -0,0 +1,182 @@
+package serviceaccounttoken
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// refreshThreshold is the fraction of a token's total lifetime after which it is considered
+// due for renewal, both by TokenSource.Token and by RunTokenRefresher.
+const refreshThreshold = 0.7
+
+var (
+	tokenIssuancesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rancher_serviceaccounttoken_bound_issuances_total",
+		Help: "Total number of bound service account tokens minted via the TokenRequest API.",
+	}, []string{"namespace", "service_account"})
+
+	tokenRefreshesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rancher_serviceaccounttoken_bound_refreshes_total",
+		Help: "Total number of bound service account tokens refreshed ahead of expiry.",
+	}, []string{"namespace", "service_account"})
+
+	tokenFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rancher_serviceaccounttoken_bound_failures_total",
+		Help: "Total number of failed attempts to mint or refresh a bound service account token.",
+	}, []string{"namespace", "service_account", "reason"})
+)
+
+// tokenCache holds every boundToken this process has ever minted, keyed by namespace/name/audiences.
+var tokenCache sync.Map // map[tokenCacheKey]*boundToken
+
+// tokenCacheKey identifies a single cached bound token. Audiences are joined so the key remains comparable.
+type tokenCacheKey struct {
+	namespace string
+	name      string
+	audiences string
+}
+
+func newTokenCacheKey(namespace, name string, audiences []string) tokenCacheKey {
+	return tokenCacheKey{namespace: namespace, name: name, audiences: strings.Join(audiences, ",")}
+}
+
+// TokenSource returns a live bound service account token, transparently minting or refreshing it
+// as needed so callers never have to read or watch a backing Secret.
+type TokenSource interface {
+	// Token returns the current token, refreshing it first if it has crossed refreshThreshold of its TTL.
+	Token(ctx context.Context) (string, error)
+}
+
+// boundToken is a TokenSource backed by the TokenRequest API. It is cached process-wide so repeated
+// calls to EnsureBoundTokenForServiceAccount for the same service account and audiences reuse it.
+type boundToken struct {
+	clientSet kubernetes.Interface
+	namespace string
+	name      string
+	audiences []string
+	ttl       time.Duration
+
+	mu        sync.Mutex
+	token     string
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+// EnsureBoundTokenForServiceAccount returns a TokenSource that produces short-lived JWTs for sa via
+// the serviceaccounts/token subresource, minting one immediately if none is cached yet. audiences may
+// be nil to request a token bound to the kube-apiserver's default audience. The returned TokenSource
+// is cached in-memory keyed by {namespace, service account, audiences} and is safe for concurrent use.
+func EnsureBoundTokenForServiceAccount(ctx context.Context, clientSet kubernetes.Interface, sa *v1.ServiceAccount, audiences []string, ttl time.Duration) (TokenSource, error) {
+	if sa == nil {
+		return nil, fmt.Errorf("could not ensure bound token for invalid service account")
+	}
+
+	key := newTokenCacheKey(sa.Namespace, sa.Name, audiences)
+	actual, _ := tokenCache.LoadOrStore(key, &boundToken{
+		clientSet: clientSet,
+		namespace: sa.Namespace,
+		name:      sa.Name,
+		audiences: audiences,
+		ttl:       ttl,
+	})
+	bt := actual.(*boundToken)
+
+	if _, err := bt.Token(ctx); err != nil {
+		return nil, err
+	}
+	return bt, nil
+}
+
+// Token implements TokenSource.
+func (b *boundToken) Token(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.token != "" && !b.needsRefreshLocked() {
+		return b.token, nil
+	}
+
+	isRefresh := b.token != ""
+	if err := b.requestLocked(ctx); err != nil {
+		reason := "issue"
+		if isRefresh {
+			reason = "refresh"
+		}
+		tokenFailuresTotal.WithLabelValues(b.namespace, b.name, reason).Inc()
+		return "", err
+	}
+	if isRefresh {
+		tokenRefreshesTotal.WithLabelValues(b.namespace, b.name).Inc()
+	} else {
+		tokenIssuancesTotal.WithLabelValues(b.namespace, b.name).Inc()
+	}
+	return b.token, nil
+}
+
+// needsRefreshLocked reports whether the cached token has crossed refreshThreshold of its lifetime.
+// b.mu must be held.
+func (b *boundToken) needsRefreshLocked() bool {
+	lifetime := b.expiresAt.Sub(b.issuedAt)
+	if lifetime <= 0 {
+		return true
+	}
+	return time.Now().After(b.issuedAt.Add(time.Duration(float64(lifetime) * refreshThreshold)))
+}
+
+// requestLocked calls the TokenRequest subresource and stores the result. b.mu must be held.
+func (b *boundToken) requestLocked(ctx context.Context) error {
+	var expirationSeconds *int64
+	if b.ttl > 0 {
+		expirationSeconds = ptrInt64(int64(b.ttl.Seconds()))
+	}
+	tr, err := b.clientSet.CoreV1().ServiceAccounts(b.namespace).CreateToken(ctx, b.name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         b.audiences,
+			ExpirationSeconds: expirationSeconds,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("error requesting bound token for service account [%s:%s]: %w", b.namespace, b.name, err)
+	}
+	b.token = tr.Status.Token
+	b.issuedAt = time.Now()
+	b.expiresAt = tr.Status.ExpirationTimestamp.Time
+	return nil
+}
+
+func ptrInt64(v int64) *int64 {
+	return &v
+}
+
+// RunTokenRefresher periodically walks every bound token this process has minted and refreshes any
+// that have crossed refreshThreshold of their lifetime, so TokenSource.Token rarely has to block a
+// caller on a live TokenRequest call. It runs until ctx is cancelled.
+func RunTokenRefresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tokenCache.Range(func(_, value any) bool {
+				bt := value.(*boundToken)
+				if _, err := bt.Token(ctx); err != nil {
+					logrus.Errorf("RunTokenRefresher: error refreshing bound token for service account [%s:%s]: %v", bt.namespace, bt.name, err)
+				}
+				return true
+			})
+		}
+	}
+}
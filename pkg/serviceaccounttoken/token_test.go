@@ -0,0 +1,102 @@
+package serviceaccounttoken
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// stubTokenRequests installs a reactor that answers every CreateToken call for clientSet with a
+// freshly numbered token, standing in for the apiserver's TokenRequest subresource.
+func stubTokenRequests(clientSet *fake.Clientset, expiresIn time.Duration) *int {
+	calls := 0
+	clientSet.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		calls++
+		createAction := action.(k8stesting.CreateAction)
+		tr := createAction.GetObject().(*authenticationv1.TokenRequest).DeepCopy()
+		tr.Status = authenticationv1.TokenRequestStatus{
+			Token:               fmt.Sprintf("token-%d", calls),
+			ExpirationTimestamp: metav1.NewTime(time.Now().Add(expiresIn)),
+		}
+		return true, tr, nil
+	})
+	return &calls
+}
+
+func TestEnsureBoundTokenForServiceAccount_NilServiceAccount(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	_, err := EnsureBoundTokenForServiceAccount(context.Background(), clientSet, nil, nil, time.Hour)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid service account")
+}
+
+func TestEnsureBoundTokenForServiceAccount_IssuesAndCaches(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	calls := stubTokenRequests(clientSet, time.Hour)
+	sa := newTestServiceAccount("default", t.Name())
+
+	source, err := EnsureBoundTokenForServiceAccount(context.Background(), clientSet, sa, nil, time.Hour)
+	require.NoError(t, err)
+
+	token, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token)
+	assert.Equal(t, 1, *calls)
+
+	// A second Ensure call for the same service account and audiences returns the cached
+	// TokenSource, and Token doesn't request a new one before it's due for refresh.
+	source2, err := EnsureBoundTokenForServiceAccount(context.Background(), clientSet, sa, nil, time.Hour)
+	require.NoError(t, err)
+	token2, err := source2.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token2)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestEnsureBoundTokenForServiceAccount_RefreshesNearExpiry(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	calls := stubTokenRequests(clientSet, time.Second)
+	sa := newTestServiceAccount("default", t.Name())
+
+	source, err := EnsureBoundTokenForServiceAccount(context.Background(), clientSet, sa, nil, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+
+	bt := source.(*boundToken)
+	bt.mu.Lock()
+	bt.issuedAt = time.Now().Add(-900 * time.Millisecond)
+	bt.expiresAt = time.Now().Add(100 * time.Millisecond)
+	bt.mu.Unlock()
+
+	token, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", token)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestNeedsRefreshLocked(t *testing.T) {
+	now := time.Now()
+
+	pastThreshold := &boundToken{token: "x", issuedAt: now.Add(-80 * time.Minute), expiresAt: now.Add(20 * time.Minute)}
+	assert.True(t, pastThreshold.needsRefreshLocked())
+
+	withinThreshold := &boundToken{token: "x", issuedAt: now.Add(-10 * time.Minute), expiresAt: now.Add(90 * time.Minute)}
+	assert.False(t, withinThreshold.needsRefreshLocked())
+}
+
+func TestNeedsRefreshLocked_ZeroLifetime(t *testing.T) {
+	assert.True(t, (&boundToken{}).needsRefreshLocked())
+}
@@ -5,30 +5,26 @@ package airgap
 import (
 	"testing"
 
-	"github.com/rancher/rancher/tests/v2/validation/pipeline/rancherha/corralha"
 	"github.com/rancher/rancher/tests/v2/validation/provisioning/permutations"
-	"github.com/rancher/rancher/tests/v2/validation/provisioning/registries"
-	"github.com/rancher/shepherd/clients/corral"
 	"github.com/rancher/shepherd/clients/rancher"
 	"github.com/rancher/shepherd/extensions/clusters"
 	"github.com/rancher/shepherd/extensions/clusters/kubernetesversions"
 	provisioning "github.com/rancher/shepherd/extensions/provisioning"
 	"github.com/rancher/shepherd/extensions/provisioninginput"
 	"github.com/rancher/shepherd/extensions/reports"
-	"github.com/rancher/shepherd/pkg/config"
-	"github.com/rancher/shepherd/pkg/session"
-	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
+const (
+	corralRegistryUsername = "registryUsername"
+	corralRegistryPassword = "registryPassword"
+	corralRegistryCABundle = "registryCABundle"
+)
+
 type AirGapRKE1CustomClusterTestSuite struct {
 	suite.Suite
-	client         *rancher.Client
-	session        *session.Session
-	corralPackage  *corral.Packages
-	clustersConfig *provisioninginput.Config
-	registryFQDN   string
+	*airgapSuiteState
 }
 
 func (a *AirGapRKE1CustomClusterTestSuite) TearDownSuite() {
@@ -36,51 +32,7 @@ func (a *AirGapRKE1CustomClusterTestSuite) TearDownSuite() {
 }
 
 func (a *AirGapRKE1CustomClusterTestSuite) SetupSuite() {
-	testSession := session.NewSession()
-	a.session = testSession
-
-	a.clustersConfig = new(provisioninginput.Config)
-	config.LoadConfig(provisioninginput.ConfigurationFileKey, a.clustersConfig)
-
-	corralRancherHA := new(corralha.CorralRancherHA)
-	config.LoadConfig(corralha.CorralRancherHAConfigConfigurationFileKey, corralRancherHA)
-
-	registriesConfig := new(registries.Registries)
-	config.LoadConfig(registries.RegistriesConfigKey, registriesConfig)
-
-	client, err := rancher.NewClient("", testSession)
-	require.NoError(a.T(), err)
-
-	a.client = client
-	listOfCorrals, err := corral.ListCorral()
-	require.NoError(a.T(), err)
-
-	corralConfig := corral.Configurations()
-
-	err = corral.SetupCorralConfig(corralConfig.CorralConfigVars, corralConfig.CorralConfigUser, corralConfig.CorralSSHPath)
-	require.NoError(a.T(), err)
-
-	a.corralPackage = corral.PackagesConfig()
-
-	_, corralExist := listOfCorrals[corralRancherHA.Name]
-	if corralExist {
-		bastionIP, err := corral.GetCorralEnvVar(corralRancherHA.Name, corralRegistryIP)
-		require.NoError(a.T(), err)
-
-		err = corral.UpdateCorralConfig(corralBastionIP, bastionIP)
-		require.NoError(a.T(), err)
-
-		registryFQDN, err := corral.GetCorralEnvVar(corralRancherHA.Name, corralRegistryFQDN)
-		require.NoError(a.T(), err)
-		logrus.Infof("registry fqdn is %s", registryFQDN)
-
-		err = corral.SetCorralSSHKeys(corralRancherHA.Name)
-		require.NoError(a.T(), err)
-		a.registryFQDN = registryFQDN
-	} else {
-		a.registryFQDN = registriesConfig.ExistingNoAuthRegistryURL
-	}
-
+	a.airgapSuiteState = setupAirgapSuite(&a.Suite)
 }
 
 func (a *AirGapRKE1CustomClusterTestSuite) TestProvisioningAirGapRKE1CustomCluster() {
@@ -126,6 +78,26 @@ func (a *AirGapRKE1CustomClusterTestSuite) TestProvisioningUpgradeAirGapRKE1Cust
 	provisioning.VerifyUpgrade(a.T(), upgradedCluster, rke1Versions[numOfRKE1Versions-1])
 }
 
+// TestProvisioningAirGapAuthRegistryRKE1CustomCluster exercises the (no-auth, basic-auth, mTLS-auth) x
+// (Harbor, Zot, distribution/registry) matrix configured under registries.authRegistries. A flavor is
+// skipped if a.registriesConfig has no entry for it, and an auth type is skipped if that flavor has no
+// URL configured for it, so this is a no-op unless the combination is explicitly provided in config.
+func (a *AirGapRKE1CustomClusterTestSuite) TestProvisioningAirGapAuthRegistryRKE1CustomCluster() {
+	a.clustersConfig.NodePools = []provisioninginput.NodePools{provisioninginput.AllRolesNodePool}
+
+	runAirGapAuthRegistryMatrix(&a.Suite, a.airgapSuiteState, func(registryURL string) {
+		testConfig := clusters.ConvertConfigToClusterConfig(a.clustersConfig)
+		testConfig.KubernetesVersion = a.clustersConfig.RKE1KubernetesVersions[0]
+		testConfig.CNI = a.clustersConfig.CNIs[0]
+
+		clusterObject, err := provisioning.CreateProvisioningRKE1AirgapCustomCluster(a.client, testConfig, a.corralPackage)
+		reports.TimeoutRKEReport(clusterObject, err)
+		require.NoError(a.T(), err)
+
+		provisioning.VerifyRKE1Cluster(a.T(), a.client, testConfig, clusterObject)
+	})
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestAirGapCustomClusterRKE1ProvisioningTestSuite(t *testing.T) {
@@ -0,0 +1,125 @@
+//go:build validation
+
+package airgap
+
+import (
+	"testing"
+
+	"github.com/rancher/rancher/tests/v2/validation/provisioning/permutations"
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/rancher/shepherd/extensions/clusters"
+	provisioning "github.com/rancher/shepherd/extensions/provisioning"
+	"github.com/rancher/shepherd/extensions/provisioninginput"
+	"github.com/rancher/shepherd/extensions/reports"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// AirGapRKE2CustomClusterTestSuite and AirGapK3sCustomClusterTestSuite mirror
+// AirGapRKE1CustomClusterTestSuite so RKE2 and K3s get the same airgap+auth-registry coverage,
+// since production airgap installs increasingly target RKE2.
+type AirGapRKE2CustomClusterTestSuite struct {
+	suite.Suite
+	*airgapSuiteState
+}
+
+func (a *AirGapRKE2CustomClusterTestSuite) TearDownSuite() {
+	a.session.Cleanup()
+}
+
+func (a *AirGapRKE2CustomClusterTestSuite) SetupSuite() {
+	a.airgapSuiteState = setupAirgapSuite(&a.Suite)
+}
+
+func (a *AirGapRKE2CustomClusterTestSuite) TestProvisioningAirGapRKE2CustomCluster() {
+	a.clustersConfig.NodePools = []provisioninginput.NodePools{provisioninginput.AllRolesNodePool}
+
+	tests := []struct {
+		name   string
+		client *rancher.Client
+	}{
+		{provisioninginput.AdminClientName.String() + "-" + permutations.RKE2AirgapCluster + "-", a.client},
+	}
+	for _, tt := range tests {
+		permutations.RunTestPermutations(&a.Suite, tt.name, tt.client, a.clustersConfig, permutations.RKE2AirgapCluster, nil, a.corralPackage)
+	}
+}
+
+// TestProvisioningAirGapAuthRegistryRKE2CustomCluster exercises the (no-auth, basic-auth, mTLS-auth) x
+// (Harbor, Zot, distribution/registry) matrix configured under registries.authRegistries. A flavor is
+// skipped if a.registriesConfig has no entry for it, and an auth type is skipped if that flavor has no
+// URL configured for it, so this is a no-op unless the combination is explicitly provided in config.
+func (a *AirGapRKE2CustomClusterTestSuite) TestProvisioningAirGapAuthRegistryRKE2CustomCluster() {
+	a.clustersConfig.NodePools = []provisioninginput.NodePools{provisioninginput.AllRolesNodePool}
+
+	runAirGapAuthRegistryMatrix(&a.Suite, a.airgapSuiteState, func(registryURL string) {
+		testConfig := clusters.ConvertConfigToClusterConfig(a.clustersConfig)
+		testConfig.KubernetesVersion = a.clustersConfig.RKE2KubernetesVersions[0]
+		testConfig.CNI = a.clustersConfig.CNIs[0]
+
+		clusterObject, err := provisioning.CreateProvisioningRKE2AirgapCustomCluster(a.client, testConfig, a.corralPackage)
+		reports.TimeoutRKEReport(clusterObject, err)
+		require.NoError(a.T(), err)
+
+		provisioning.VerifyRKE2Cluster(a.T(), a.client, testConfig, clusterObject)
+	})
+}
+
+// In order for 'go test' to run this suite, we need to create
+// a normal test function and pass our suite to suite.Run
+func TestAirGapCustomClusterRKE2ProvisioningTestSuite(t *testing.T) {
+	suite.Run(t, new(AirGapRKE2CustomClusterTestSuite))
+}
+
+type AirGapK3sCustomClusterTestSuite struct {
+	suite.Suite
+	*airgapSuiteState
+}
+
+func (a *AirGapK3sCustomClusterTestSuite) TearDownSuite() {
+	a.session.Cleanup()
+}
+
+func (a *AirGapK3sCustomClusterTestSuite) SetupSuite() {
+	a.airgapSuiteState = setupAirgapSuite(&a.Suite)
+}
+
+func (a *AirGapK3sCustomClusterTestSuite) TestProvisioningAirGapK3sCustomCluster() {
+	a.clustersConfig.NodePools = []provisioninginput.NodePools{provisioninginput.AllRolesNodePool}
+
+	tests := []struct {
+		name   string
+		client *rancher.Client
+	}{
+		{provisioninginput.AdminClientName.String() + "-" + permutations.K3SAirgapCluster + "-", a.client},
+	}
+	for _, tt := range tests {
+		permutations.RunTestPermutations(&a.Suite, tt.name, tt.client, a.clustersConfig, permutations.K3SAirgapCluster, nil, a.corralPackage)
+	}
+}
+
+// TestProvisioningAirGapAuthRegistryK3sCustomCluster exercises the (no-auth, basic-auth, mTLS-auth) x
+// (Harbor, Zot, distribution/registry) matrix configured under registries.authRegistries. A flavor is
+// skipped if a.registriesConfig has no entry for it, and an auth type is skipped if that flavor has no
+// URL configured for it, so this is a no-op unless the combination is explicitly provided in config.
+func (a *AirGapK3sCustomClusterTestSuite) TestProvisioningAirGapAuthRegistryK3sCustomCluster() {
+	a.clustersConfig.NodePools = []provisioninginput.NodePools{provisioninginput.AllRolesNodePool}
+
+	runAirGapAuthRegistryMatrix(&a.Suite, a.airgapSuiteState, func(registryURL string) {
+		testConfig := clusters.ConvertConfigToClusterConfig(a.clustersConfig)
+		testConfig.KubernetesVersion = a.clustersConfig.K3SKubernetesVersions[0]
+		testConfig.CNI = a.clustersConfig.CNIs[0]
+
+		clusterObject, err := provisioning.CreateProvisioningK3SAirgapCustomCluster(a.client, testConfig, a.corralPackage)
+		reports.TimeoutRKEReport(clusterObject, err)
+		require.NoError(a.T(), err)
+
+		provisioning.VerifyK3SCluster(a.T(), a.client, testConfig, clusterObject)
+	})
+}
+
+// In order for 'go test' to run this suite, we need to create
+// a normal test function and pass our suite to suite.Run
+func TestAirGapCustomClusterK3sProvisioningTestSuite(t *testing.T) {
+	suite.Run(t, new(AirGapK3sCustomClusterTestSuite))
+}
@@ -0,0 +1,132 @@
+//go:build validation
+
+package airgap
+
+import (
+	"fmt"
+
+	"github.com/rancher/rancher/tests/v2/validation/pipeline/rancherha/corralha"
+	"github.com/rancher/rancher/tests/v2/validation/provisioning/registries"
+	"github.com/rancher/shepherd/clients/corral"
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/rancher/shepherd/extensions/provisioninginput"
+	"github.com/rancher/shepherd/pkg/config"
+	"github.com/rancher/shepherd/pkg/session"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// airgapSuiteState holds the session, client, and config shared by every airgap custom-cluster test
+// suite (RKE1, RKE2, K3s); their SetupSuite/TearDownSuite implementations were otherwise identical.
+type airgapSuiteState struct {
+	client           *rancher.Client
+	session          *session.Session
+	corralPackage    *corral.Packages
+	clustersConfig   *provisioninginput.Config
+	registriesConfig *registries.Registries
+	registryFQDN     string
+}
+
+// setupAirgapSuite builds an airgapSuiteState for s: it loads the provisioning and registries
+// config, creates a Rancher client, and resolves registryFQDN from the airgap corral if one has
+// already been set up, falling back to registriesConfig.ExistingNoAuthRegistryURL otherwise.
+func setupAirgapSuite(s *suite.Suite) *airgapSuiteState {
+	state := &airgapSuiteState{
+		session: session.NewSession(),
+	}
+
+	state.clustersConfig = new(provisioninginput.Config)
+	config.LoadConfig(provisioninginput.ConfigurationFileKey, state.clustersConfig)
+
+	corralRancherHA := new(corralha.CorralRancherHA)
+	config.LoadConfig(corralha.CorralRancherHAConfigConfigurationFileKey, corralRancherHA)
+
+	state.registriesConfig = new(registries.Registries)
+	config.LoadConfig(registries.RegistriesConfigKey, state.registriesConfig)
+
+	client, err := rancher.NewClient("", state.session)
+	require.NoError(s.T(), err)
+	state.client = client
+
+	listOfCorrals, err := corral.ListCorral()
+	require.NoError(s.T(), err)
+
+	corralConfig := corral.Configurations()
+	err = corral.SetupCorralConfig(corralConfig.CorralConfigVars, corralConfig.CorralConfigUser, corralConfig.CorralSSHPath)
+	require.NoError(s.T(), err)
+
+	state.corralPackage = corral.PackagesConfig()
+
+	_, corralExist := listOfCorrals[corralRancherHA.Name]
+	if corralExist {
+		bastionIP, err := corral.GetCorralEnvVar(corralRancherHA.Name, corralRegistryIP)
+		require.NoError(s.T(), err)
+
+		err = corral.UpdateCorralConfig(corralBastionIP, bastionIP)
+		require.NoError(s.T(), err)
+
+		registryFQDN, err := corral.GetCorralEnvVar(corralRancherHA.Name, corralRegistryFQDN)
+		require.NoError(s.T(), err)
+		logrus.Infof("registry fqdn is %s", registryFQDN)
+
+		err = corral.SetCorralSSHKeys(corralRancherHA.Name)
+		require.NoError(s.T(), err)
+		state.registryFQDN = registryFQDN
+	} else {
+		state.registryFQDN = state.registriesConfig.ExistingNoAuthRegistryURL
+	}
+
+	return state
+}
+
+// applyAuthRegistryCorralConfig pushes the credentials/CA bundle for registryURL into the corral
+// config so the registries.yaml/registry-config generation used by the CreateProvisioning*AirgapCustomCluster
+// helpers picks them up for this run.
+func applyAuthRegistryCorralConfig(registryURL string, authRegistry registries.AuthRegistry, authType registries.AuthType) error {
+	if err := corral.UpdateCorralConfig(corralRegistryFQDN, registryURL); err != nil {
+		return err
+	}
+	if authType == registries.NoAuth {
+		return nil
+	}
+	if err := corral.UpdateCorralConfig(corralRegistryUsername, authRegistry.Username); err != nil {
+		return err
+	}
+	if err := corral.UpdateCorralConfig(corralRegistryPassword, authRegistry.Password); err != nil {
+		return err
+	}
+	return corral.UpdateCorralConfig(corralRegistryCABundle, authRegistry.CABundle)
+}
+
+// runAirGapAuthRegistryMatrix exercises the (no-auth, basic-auth, mTLS) x (Harbor, Zot,
+// distribution/registry) matrix configured under state.registriesConfig.authRegistries, calling
+// provision for each enabled combination after pushing its credentials into the corral config. A
+// flavor is skipped if state has no entry for it, and an auth type is skipped if that flavor has no
+// URL configured for it, so this is a no-op unless the combination is explicitly provided in config.
+func runAirGapAuthRegistryMatrix(s *suite.Suite, state *airgapSuiteState, provision func(registryURL string)) {
+	authTypes := []registries.AuthType{registries.NoAuth, registries.BasicAuth, registries.MTLSAuth}
+	flavors := []registries.RegistryFlavor{registries.HarborRegistry, registries.ZotRegistry, registries.DistributionRegistry}
+
+	for _, flavor := range flavors {
+		authRegistry, ok := state.registriesConfig.AuthRegistries[flavor]
+		if !ok {
+			logrus.Infof("no authRegistries entry configured for %s, skipping", flavor)
+			continue
+		}
+		for _, authType := range authTypes {
+			registryURL, ok := authRegistry.URLFor(authType)
+			if !ok {
+				logrus.Infof("no %s URL configured for %s, skipping", authType, flavor)
+				continue
+			}
+
+			s.Run(fmt.Sprintf("%s-%s", flavor, authType), func() {
+				err := applyAuthRegistryCorralConfig(registryURL, authRegistry, authType)
+				require.NoError(s.T(), err)
+
+				provision(registryURL)
+			})
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package registries
+
+// RegistriesConfigKey is the key used to load Registries from the config file.
+const RegistriesConfigKey = "registries"
+
+// AuthType identifies how a test registry expects clients to authenticate.
+type AuthType string
+
+const (
+	// NoAuth is an anonymous, unauthenticated OCI-distribution registry.
+	NoAuth AuthType = "noAuth"
+	// BasicAuth is an OCI-distribution registry gated by a username/password.
+	BasicAuth AuthType = "basicAuth"
+	// MTLSAuth is an OCI-distribution registry that requires a client certificate.
+	MTLSAuth AuthType = "mtlsAuth"
+)
+
+// RegistryFlavor identifies which registry implementation a test is run against.
+type RegistryFlavor string
+
+const (
+	// HarborRegistry is a Harbor-backed OCI-distribution registry.
+	HarborRegistry RegistryFlavor = "harbor"
+	// ZotRegistry is a zot-backed OCI-distribution registry.
+	ZotRegistry RegistryFlavor = "zot"
+	// DistributionRegistry is a distribution/distribution-backed OCI-distribution registry.
+	DistributionRegistry RegistryFlavor = "distribution"
+)
+
+// Registries is the config field that holds the registry URLs and credentials used by airgap tests.
+type Registries struct {
+	// ExistingNoAuthRegistryURL is a pre-provisioned registry that requires no authentication.
+	ExistingNoAuthRegistryURL string `json:"existingNoAuthRegistryURL" yaml:"existingNoAuthRegistryURL"`
+
+	// Username is the basic-auth username for an authenticated registry.
+	Username string `json:"username" yaml:"username"`
+	// Password is the basic-auth password for an authenticated registry.
+	Password string `json:"password" yaml:"password"`
+	// CABundle is the PEM-encoded CA (and, for mTLS, client certificate material) used to trust and,
+	// where required, authenticate to an authenticated registry.
+	CABundle string `json:"caBundle" yaml:"caBundle"`
+	// Mirrors maps an upstream registry host to the authenticated mirror that should serve it, mirroring
+	// the `mirrors` block of RKE1/RKE2/K3s registry configuration.
+	Mirrors map[string]RegistryMirror `json:"mirrors" yaml:"mirrors"`
+
+	// AuthRegistries holds the set of authenticated registries to exercise in the matrix test,
+	// keyed by flavor (harbor/zot/distribution). A flavor is skipped if it has no entry here.
+	AuthRegistries map[RegistryFlavor]AuthRegistry `json:"authRegistries" yaml:"authRegistries"`
+}
+
+// RegistryMirror is a single entry of the `mirrors` block of RKE1/RKE2/K3s registry configuration.
+type RegistryMirror struct {
+	Endpoints []string          `json:"endpoints" yaml:"endpoints"`
+	Rewrites  map[string]string `json:"rewrites,omitempty" yaml:"rewrites,omitempty"`
+}
+
+// AuthRegistry is a single authenticated registry endpoint to run the (no-auth, basic-auth, mTLS)
+// matrix against for one RegistryFlavor.
+type AuthRegistry struct {
+	NoAuthURL    string `json:"noAuthURL,omitempty" yaml:"noAuthURL,omitempty"`
+	BasicAuthURL string `json:"basicAuthURL,omitempty" yaml:"basicAuthURL,omitempty"`
+	MTLSAuthURL  string `json:"mtlsAuthURL,omitempty" yaml:"mtlsAuthURL,omitempty"`
+
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	CABundle string `json:"caBundle,omitempty" yaml:"caBundle,omitempty"`
+}
+
+// URLFor returns the registry URL configured for the given auth type, and whether one was configured.
+func (a AuthRegistry) URLFor(auth AuthType) (string, bool) {
+	switch auth {
+	case NoAuth:
+		return a.NoAuthURL, a.NoAuthURL != ""
+	case BasicAuth:
+		return a.BasicAuthURL, a.BasicAuthURL != ""
+	case MTLSAuth:
+		return a.MTLSAuthURL, a.MTLSAuthURL != ""
+	default:
+		return "", false
+	}
+}